@@ -1,13 +1,21 @@
 package main // import "github.com/nildev/api-host"
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/nildev/api-host/config"
 	"github.com/nildev/api-host/server"
 	"github.com/nildev/api-host/version"
@@ -84,6 +92,48 @@ func main() {
 	cfgset.Bool("cors_debug", false, "Debugging flag adds additional output to debug server side CORS issues.")
 	// JWT
 	cfgset.String("jwt_sign_key", "", "JWT signing key")
+	// Admission control
+	cfgset.Int("max_requests_in_flight", 0, "Maximum number of non-long-running requests served concurrently. 0 disables the limit.")
+	cfgset.String("long_running_request_regex", "", "Regex matched against the request path to classify long-running requests (e.g. watch/stream endpoints), exempting them from the in-flight limit and request timeout.")
+	cfgset.Duration("request_timeout", 0, "Maximum duration a non-long-running request may run before being aborted. 0 disables the timeout.")
+	// Auth
+	cfgset.String("auth_mode", "", "Authentication mode: jwt, oidc, or chain. Defaults to jwt if jwt_sign_key is set, otherwise authentication is disabled.")
+	cfgset.String("oidc_issuer_url", "", "OIDC issuer URL used for discovery of the JWKS endpoint")
+	cfgset.String("oidc_client_id", "", "OIDC client ID")
+	cfgset.String("oidc_audience", "", "Required audience (aud) claim for OIDC tokens")
+	cfgset.Duration("oidc_jwks_refresh_interval", 5*time.Minute, "How often to refresh the OIDC issuer's JWKS")
+	cfgset.String("oidc_required_claims", "", "Comma separated key=value pairs that OIDC tokens must satisfy")
+	// Rate limiting and circuit breaking
+	cfgset.Bool("ratelimit_enabled", false, "Enable per-route, per-client rate limiting")
+	cfgset.Float64("ratelimit_default_rps", 10, "Default requests-per-second allowed per client for routes with no matching ratelimit_rules entry")
+	cfgset.Int("ratelimit_burst", 20, "Default token bucket burst size")
+	cfgset.String("ratelimit_rules", "", `JSON array overriding rps/burst/key per route, e.g. [{"route":"^/search","rps":5,"burst":10,"key":"ip"}]`)
+	cfgset.String("ratelimit_trusted_proxies", "", "Comma separated CIDRs trusted to set X-Forwarded-For when keying the rate limiter by client IP")
+	cfgset.Float64("cb_failure_ratio", 0.5, "Failure ratio that trips a route's circuit breaker open")
+	cfgset.Int("cb_min_requests", 0, "Minimum requests observed before a route's circuit breaker can trip. 0 disables circuit breaking.")
+	cfgset.Duration("cb_open_duration", 30*time.Second, "How long a tripped circuit breaker stays open before allowing a half-open trial request")
+	// Observability
+	cfgset.String("metrics_path", "/metrics", "Path Prometheus metrics are served on")
+	cfgset.String("metrics_listen", "", "If set, serve Prometheus metrics on this address instead of the main mux, e.g. :9090")
+	cfgset.String("health_listen", "", "If set, serve /healthz on this plaintext address instead of the main mux, bypassing TLS and tls_client_auth; use a loopback address so the systemd watchdog probe still works under require/require-and-verify")
+	cfgset.Bool("otel_enabled", false, "Enable OpenTelemetry HTTP tracing")
+	cfgset.String("otel_endpoint", "", "OTLP HTTP exporter endpoint")
+	cfgset.Float64("otel_sample_ratio", 1.0, "Fraction of requests sampled for tracing, between 0 and 1")
+	cfgset.String("otel_service_name", "apihostd", "Service name reported in trace resource attributes")
+	// Shutdown
+	cfgset.Duration("shutdown_timeout", 30*time.Second, "Maximum time graceful shutdown waits for in-flight requests to finish")
+	// TLS
+	cfgset.String("tls_cert_file", "", "Path to a PEM certificate to serve over TLS. Hot-reloaded on change. Ignored when acme_enabled is set.")
+	cfgset.String("tls_key_file", "", "Path to the PEM private key matching tls_cert_file")
+	cfgset.String("tls_min_version", "1.2", "Minimum TLS version to accept: 1.0, 1.1, 1.2, or 1.3")
+	cfgset.String("tls_client_ca", "", "Path to a PEM file of CAs used to verify client certificates")
+	cfgset.String("tls_client_auth", "none", "Client certificate requirement: none, request, require, verify-if-given, or require-and-verify")
+	cfgset.String("tls_ciphers", "", "Comma separated list of allowed TLS cipher suite names. Empty uses Go's defaults.")
+	cfgset.Bool("acme_enabled", false, "Obtain TLS certificates automatically via ACME instead of tls_cert_file/tls_key_file")
+	cfgset.String("acme_email", "", "Contact email registered with the ACME account")
+	cfgset.String("acme_hosts", "", "Comma separated hostnames ACME is allowed to issue certificates for")
+	cfgset.String("acme_cache_dir", "/var/lib/api-host/acme-cache", "Directory ACME certificates and account keys are cached in")
+	cfgset.String("acme_directory_url", "", "ACME directory URL. Empty uses Let's Encrypt's production directory.")
 
 	globalconf.Register("", cfgset)
 	cfg, err := getConfig(cfgset, *cfgPath)
@@ -95,7 +145,14 @@ func main() {
 	if err != nil {
 		ctxLog.Fatalf("Failed creating Server: %v", err.Error())
 	}
-	srv.Run()
+	if err := srv.Run(); err != nil {
+		ctxLog.Fatalf("Failed starting Server: %v", err.Error())
+	}
+
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		ctxLog.Warnf("Failed to notify systemd readiness: %v", err)
+	}
+	stopWatchdog := startWatchdog(cfg)
 
 	reconfigure := func() {
 		ctxLog.Infof("Reloading configuration from %s", *cfgPath)
@@ -112,12 +169,31 @@ func main() {
 		if err != nil {
 			ctxLog.Fatalf(err.Error())
 		}
-		srv.Run()
+		if err := srv.Run(); err != nil {
+			ctxLog.Fatalf("Failed starting Server: %v", err.Error())
+		}
+
+		// Restart the watchdog probe against the new config: ip/port/TLS
+		// settings may have changed, and the old goroutine would otherwise
+		// keep probing the stale address forever.
+		stopWatchdog()
+		stopWatchdog = startWatchdog(cfg)
 	}
 
 	shutdown := func() {
 		ctxLog.Infof("Gracefully shutting down")
-		srv.Stop()
+
+		if _, err := daemon.SdNotify(false, daemon.SdNotifyStopping); err != nil {
+			ctxLog.Warnf("Failed to notify systemd of stopping: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), srv.Config.ShutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			ctxLog.Warnf("Graceful shutdown did not complete cleanly: %v", err)
+		}
+
 		srv.Purge()
 		os.Exit(0)
 	}
@@ -188,7 +264,57 @@ func getConfig(flagset *flag.FlagSet, userCfgFile string) (*config.Config, error
 		CORSMaxAge:             (*flagset.Lookup("cors_max_age")).Value.(flag.Getter).Get().(int),
 		CORSOptionsPassThrough: (*flagset.Lookup("cors_options_pass_through")).Value.(flag.Getter).Get().(bool),
 		CORSDebug:              (*flagset.Lookup("cors_debug")).Value.(flag.Getter).Get().(bool),
+
+		MaxRequestsInFlight:  (*flagset.Lookup("max_requests_in_flight")).Value.(flag.Getter).Get().(int),
+		LongRunningRequestRE: (*flagset.Lookup("long_running_request_regex")).Value.(flag.Getter).Get().(string),
+		RequestTimeout:       (*flagset.Lookup("request_timeout")).Value.(flag.Getter).Get().(time.Duration),
+
+		AuthMode:                (*flagset.Lookup("auth_mode")).Value.(flag.Getter).Get().(string),
+		OIDCIssuerURL:           (*flagset.Lookup("oidc_issuer_url")).Value.(flag.Getter).Get().(string),
+		OIDCClientID:            (*flagset.Lookup("oidc_client_id")).Value.(flag.Getter).Get().(string),
+		OIDCAudience:            (*flagset.Lookup("oidc_audience")).Value.(flag.Getter).Get().(string),
+		OIDCJWKSRefreshInterval: (*flagset.Lookup("oidc_jwks_refresh_interval")).Value.(flag.Getter).Get().(time.Duration),
+		OIDCRequiredClaims:      config.StringToMap((*flagset.Lookup("oidc_required_claims")).Value.(flag.Getter).Get().(string)),
+
+		RateLimitEnabled:        (*flagset.Lookup("ratelimit_enabled")).Value.(flag.Getter).Get().(bool),
+		RateLimitDefaultRPS:     (*flagset.Lookup("ratelimit_default_rps")).Value.(flag.Getter).Get().(float64),
+		RateLimitBurst:          (*flagset.Lookup("ratelimit_burst")).Value.(flag.Getter).Get().(int),
+		RateLimitTrustedProxies: config.StringToSlice((*flagset.Lookup("ratelimit_trusted_proxies")).Value.(flag.Getter).Get().(string)),
+
+		CBFailureRatio: (*flagset.Lookup("cb_failure_ratio")).Value.(flag.Getter).Get().(float64),
+		CBMinRequests:  (*flagset.Lookup("cb_min_requests")).Value.(flag.Getter).Get().(int),
+		CBOpenDuration: (*flagset.Lookup("cb_open_duration")).Value.(flag.Getter).Get().(time.Duration),
+
+		MetricsPath:   (*flagset.Lookup("metrics_path")).Value.(flag.Getter).Get().(string),
+		MetricsListen: (*flagset.Lookup("metrics_listen")).Value.(flag.Getter).Get().(string),
+		HealthListen:  (*flagset.Lookup("health_listen")).Value.(flag.Getter).Get().(string),
+
+		OTelEnabled:     (*flagset.Lookup("otel_enabled")).Value.(flag.Getter).Get().(bool),
+		OTelEndpoint:    (*flagset.Lookup("otel_endpoint")).Value.(flag.Getter).Get().(string),
+		OTelSampleRatio: (*flagset.Lookup("otel_sample_ratio")).Value.(flag.Getter).Get().(float64),
+		OTelServiceName: (*flagset.Lookup("otel_service_name")).Value.(flag.Getter).Get().(string),
+
+		ShutdownTimeout: (*flagset.Lookup("shutdown_timeout")).Value.(flag.Getter).Get().(time.Duration),
+
+		TLSCertFile:   (*flagset.Lookup("tls_cert_file")).Value.(flag.Getter).Get().(string),
+		TLSKeyFile:    (*flagset.Lookup("tls_key_file")).Value.(flag.Getter).Get().(string),
+		TLSMinVersion: (*flagset.Lookup("tls_min_version")).Value.(flag.Getter).Get().(string),
+		TLSClientCA:   (*flagset.Lookup("tls_client_ca")).Value.(flag.Getter).Get().(string),
+		TLSClientAuth: (*flagset.Lookup("tls_client_auth")).Value.(flag.Getter).Get().(string),
+		TLSCiphers:    config.StringToSlice((*flagset.Lookup("tls_ciphers")).Value.(flag.Getter).Get().(string)),
+
+		ACMEEnabled:      (*flagset.Lookup("acme_enabled")).Value.(flag.Getter).Get().(bool),
+		ACMEEmail:        (*flagset.Lookup("acme_email")).Value.(flag.Getter).Get().(string),
+		ACMEHosts:        config.StringToSlice((*flagset.Lookup("acme_hosts")).Value.(flag.Getter).Get().(string)),
+		ACMECacheDir:     (*flagset.Lookup("acme_cache_dir")).Value.(flag.Getter).Get().(string),
+		ACMEDirectoryURL: (*flagset.Lookup("acme_directory_url")).Value.(flag.Getter).Get().(string),
+	}
+
+	rules, err := config.ParseRateLimitRules((*flagset.Lookup("ratelimit_rules")).Value.(flag.Getter).Get().(string))
+	if err != nil {
+		return nil, err
 	}
+	cfg.RateLimitRules = rules
 
 	log.SetLevel(log.Level(cfg.Verbosity))
 
@@ -197,6 +323,95 @@ func getConfig(flagset *flag.FlagSet, userCfgFile string) (*config.Config, error
 	return &cfg, nil
 }
 
+// startWatchdog, when systemd provides WATCHDOG_USEC (i.e. the unit runs
+// under Type=notify with WatchdogSec set), pings WATCHDOG=1 at half that
+// interval for as long as a self-probe against the server's health
+// endpoint succeeds. A failing probe simply skips that ping, letting
+// systemd's watchdog timer expire and restart the unit. The probe speaks
+// https once TLS termination (static cert or ACME) is configured, since
+// the main listener no longer accepts plaintext in that case; if
+// cfg.HealthListen is set, the probe targets that separate plaintext
+// listener instead, since it has no client certificate to present against
+// tls_client_auth require/require-and-verify. It returns a stop function;
+// callers must stop the old watchdog and start a new one on every
+// reconfigure, since cfg's ip/port/TLS/health_listen settings may have
+// changed.
+func startWatchdog(cfg *config.Config) func() {
+	noop := func() {}
+
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return noop
+	}
+
+	microseconds, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || microseconds <= 0 {
+		ctxLog.Warnf("Ignoring invalid WATCHDOG_USEC=%q", usec)
+		return noop
+	}
+
+	interval := time.Duration(microseconds) * time.Microsecond / 2
+
+	var healthURL string
+	transport := &http.Transport{}
+	if cfg.HealthListen != "" {
+		healthURL = fmt.Sprintf("http://%s/healthz", cfg.HealthListen)
+	} else {
+		scheme := "http"
+		if (cfg.TLSCertFile != "" && cfg.TLSKeyFile != "") || cfg.ACMEEnabled {
+			scheme = "https"
+			// The probe only needs to confirm the listener is alive and serving;
+			// it has no way to know which CA issued the server's own certificate
+			// (and ACME certs aren't pinned anywhere), so it doesn't verify it.
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			// ACME only serves certificates for a requested host name, so the
+			// probe sends the first configured host as SNI.
+			if cfg.ACMEEnabled && len(cfg.ACMEHosts) > 0 {
+				transport.TLSClientConfig.ServerName = cfg.ACMEHosts[0]
+			}
+		}
+		healthURL = fmt.Sprintf("%s://%s/healthz", scheme, net.JoinHostPort(cfg.IP, cfg.Port))
+	}
+	client := &http.Client{Timeout: interval / 2, Transport: transport}
+
+	stopCh := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				resp, err := client.Get(healthURL)
+				if err != nil {
+					ctxLog.Warnf("Watchdog health probe failed, skipping WATCHDOG=1: %v", err)
+					continue
+				}
+				resp.Body.Close()
+
+				if resp.StatusCode != http.StatusOK {
+					ctxLog.Warnf("Watchdog health probe returned %d, skipping WATCHDOG=1", resp.StatusCode)
+					continue
+				}
+
+				if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+					ctxLog.Warnf("Failed to notify systemd watchdog: %v", err)
+				}
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+
+	var stopOnce sync.Once
+	return func() {
+		stopOnce.Do(func() {
+			close(stopCh)
+		})
+	}
+}
+
 func listenForSignals(sigmap map[os.Signal]func()) {
 	sigchan := make(chan os.Signal, 1)
 
@@ -0,0 +1,200 @@
+// Package config describes the runtime configuration of apihostd and
+// provides helpers for turning raw flag/ini values into typed settings.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Config is the fully resolved configuration for a single server.New
+// invocation. It is rebuilt from scratch on every SIGHUP reconfigure.
+type Config struct {
+	Verbosity int
+	IP        string
+	Port      string
+	// Secret is the JWT signing key (HMAC secret or RSA public key PEM).
+	// Excluded from JSON output so the SIGUSR1 state dump and any other
+	// json.Marshal of Config never echoes it.
+	Secret string `json:"-"`
+
+	CORSAllowedOrigins     []string
+	CORSAllowedMethods     []string
+	CORSAllowedHeaders     []string
+	CORSExposedHeaders     []string
+	CORSAllowCredentials   bool
+	CORSMaxAge             int
+	CORSOptionsPassThrough bool
+	CORSDebug              bool
+
+	// MaxRequestsInFlight bounds the number of concurrently served requests,
+	// excluding those classified as long-running. Zero disables the limit.
+	MaxRequestsInFlight int
+	// LongRunningRequestRE matches the path of requests (e.g. watch/stream
+	// endpoints) that are exempt from both the in-flight limit and
+	// RequestTimeout.
+	LongRunningRequestRE string
+	// RequestTimeout bounds how long a non-long-running request may run
+	// before it is aborted with a 503. Zero disables the timeout.
+	RequestTimeout time.Duration
+
+	// AuthMode selects which Authenticator(s) server.New wires up: "jwt"
+	// for the static-key verifier, "oidc" for the JWKS-backed verifier, or
+	// "chain" to try OIDC first and fall back to the static key.
+	AuthMode string
+
+	OIDCIssuerURL string
+	OIDCClientID  string
+	// OIDCAudience is the required "aud" claim. It defaults to OIDCClientID
+	// when unset, since most issuers mint tokens with the client ID as the
+	// audience; set it explicitly if your issuer uses a different value.
+	OIDCAudience            string
+	OIDCJWKSRefreshInterval time.Duration
+	// OIDCRequiredClaims is parsed from a comma separated list of
+	// key=value pairs; all must be present and equal in a token's claims.
+	OIDCRequiredClaims map[string]string
+
+	RateLimitEnabled    bool
+	RateLimitDefaultRPS float64
+	RateLimitBurst      int
+	// RateLimitRules overrides the default rps/burst/key for requests whose
+	// path matches Route; the method is not considered. Rules are tried in
+	// order; the first match wins.
+	RateLimitRules []RateLimitRule
+	// RateLimitTrustedProxies lists the CIDRs allowed to set
+	// X-Forwarded-For when the rate limiter keys by client IP.
+	RateLimitTrustedProxies []string
+
+	CBFailureRatio float64
+	CBMinRequests  int
+	CBOpenDuration time.Duration
+
+	// MetricsPath is where Prometheus metrics are served. MetricsListen, if
+	// set, serves them on a separate admin listener instead of the main mux.
+	MetricsPath   string
+	MetricsListen string
+
+	// HealthListen, if set, serves /healthz on a separate plaintext listener
+	// with no TLS and no client certificate requirement, instead of the main
+	// mux. Set this to a loopback address (e.g. "127.0.0.1:8099") when
+	// tls_client_auth is require or require-and-verify, so the systemd
+	// watchdog probe (which cannot present a client certificate) can still
+	// reach it.
+	HealthListen string
+
+	OTelEnabled     bool
+	OTelEndpoint    string
+	OTelSampleRatio float64
+	OTelServiceName string
+
+	// ShutdownTimeout bounds how long graceful shutdown waits for in-flight
+	// requests to finish before the process exits anyway.
+	ShutdownTimeout time.Duration
+
+	// TLSCertFile and TLSKeyFile, if both set, serve over TLS using a
+	// certificate loaded from disk and reloaded on change. Ignored when
+	// ACMEEnabled is true.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSMinVersion is one of "1.0", "1.1", "1.2", "1.3".
+	TLSMinVersion string
+	// TLSClientCA, if set, is a PEM file of CAs used to verify client
+	// certificates per TLSClientAuth.
+	TLSClientCA string
+	// TLSClientAuth is one of "none", "request", "require",
+	// "verify-if-given", "require-and-verify".
+	TLSClientAuth string
+	// TLSCiphers restricts the negotiated cipher suites by name, e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Empty uses Go's defaults.
+	TLSCiphers []string
+
+	ACMEEnabled bool
+	// ACMEEmail is excluded from JSON output; it's account contact PII, not
+	// operationally useful in a state dump.
+	ACMEEmail        string `json:"-"`
+	ACMEHosts        []string
+	ACMECacheDir     string
+	ACMEDirectoryURL string
+}
+
+// RateLimitRule overrides the default rate limit for requests matching
+// Route, a glob or regex matched against the request path. Key selects how
+// requests are bucketed: "ip", "jwt_subject", or "header:<Name>".
+type RateLimitRule struct {
+	Route string  `json:"route"`
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+	Key   string  `json:"key"`
+}
+
+// ParseRateLimitRules decodes the JSON array configured via
+// ratelimit_rules, e.g. `[{"route":"^/search","rps":5,"burst":10,"key":"ip"}]`.
+func ParseRateLimitRules(val string) ([]RateLimitRule, error) {
+	if val == "" {
+		return nil, nil
+	}
+
+	var rules []RateLimitRule
+	if err := json.Unmarshal([]byte(val), &rules); err != nil {
+		return nil, fmt.Errorf("config: invalid ratelimit_rules: %v", err)
+	}
+
+	return rules, nil
+}
+
+// StringToSlice splits a comma separated flag value into a trimmed slice of
+// strings, dropping empty elements. It returns nil for an empty input.
+func StringToSlice(val string) []string {
+	if val == "" {
+		return nil
+	}
+
+	parts := strings.Split(val, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		out = append(out, p)
+	}
+
+	return out
+}
+
+// StringToMap parses a comma separated list of key=value pairs into a map,
+// skipping malformed or empty entries. It returns nil for an empty input.
+func StringToMap(val string) map[string]string {
+	if val == "" {
+		return nil
+	}
+
+	out := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		k := strings.TrimSpace(kv[0])
+		v := strings.TrimSpace(kv[1])
+		if k == "" {
+			continue
+		}
+
+		out[k] = v
+	}
+
+	if len(out) == 0 {
+		return nil
+	}
+
+	return out
+}
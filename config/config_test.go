@@ -0,0 +1,82 @@
+package config
+
+import "testing"
+
+func TestStringToSlice(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"a,b,c", []string{"a", "b", "c"}},
+		{" a , b ,,c ", []string{"a", "b", "c"}},
+	}
+
+	for _, c := range cases {
+		got := StringToSlice(c.in)
+		if !slicesEqual(got, c.want) {
+			t.Errorf("StringToSlice(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestStringToMap(t *testing.T) {
+	cases := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"", nil},
+		{"a=1,b=2", map[string]string{"a": "1", "b": "2"}},
+		{" a = 1 , malformed, b=2", map[string]string{"a": "1", "b": "2"}},
+		{"=1,a=", map[string]string{"a": ""}},
+	}
+
+	for _, c := range cases {
+		got := StringToMap(c.in)
+		if !mapsEqual(got, c.want) {
+			t.Errorf("StringToMap(%q) = %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseRateLimitRules(t *testing.T) {
+	rules, err := ParseRateLimitRules(`[{"route":"^/search","rps":5,"burst":10,"key":"ip"}]`)
+	if err != nil {
+		t.Fatalf("ParseRateLimitRules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Route != "^/search" || rules[0].RPS != 5 || rules[0].Burst != 10 || rules[0].Key != "ip" {
+		t.Errorf("ParseRateLimitRules returned %+v", rules)
+	}
+
+	if rules, err := ParseRateLimitRules(""); err != nil || rules != nil {
+		t.Errorf("ParseRateLimitRules(\"\") = %v, %v, want nil, nil", rules, err)
+	}
+
+	if _, err := ParseRateLimitRules("not json"); err == nil {
+		t.Error("ParseRateLimitRules(\"not json\") should have returned an error")
+	}
+}
+
+func slicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
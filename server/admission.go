@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/nildev/api-host/config"
+)
+
+// Admission implements overload protection for the server: a semaphore
+// bounds the number of requests in flight, and requests classified as
+// long-running (e.g. watch/stream endpoints) are exempt from both the
+// in-flight limit and the request timeout, mirroring how kube-apiserver
+// separates short and long-running requests.
+type Admission struct {
+	maxInFlight   int
+	timeout       time.Duration
+	longRunningRE *regexp.Regexp
+
+	sem      chan struct{}
+	inFlight int64
+}
+
+// NewAdmission builds the admission controller from cfg. A MaxRequestsInFlight
+// of zero disables the in-flight limit; an empty LongRunningRequestRE means no
+// request is ever classified as long-running.
+func NewAdmission(cfg config.Config) (*Admission, error) {
+	a := &Admission{
+		maxInFlight: cfg.MaxRequestsInFlight,
+		timeout:     cfg.RequestTimeout,
+	}
+
+	if cfg.LongRunningRequestRE != "" {
+		re, err := regexp.Compile(cfg.LongRunningRequestRE)
+		if err != nil {
+			return nil, err
+		}
+		a.longRunningRE = re
+	}
+
+	if a.maxInFlight > 0 {
+		a.sem = make(chan struct{}, a.maxInFlight)
+	}
+
+	return a, nil
+}
+
+// isLongRunning classifies a request by matching r.URL.Path against the
+// configured long-running regex, e.g. "^/(watch|events|stream)". Matching
+// is path-only, regardless of method, matching config.LongRunningRequestRE's
+// documented contract.
+func (a *Admission) isLongRunning(r *http.Request) bool {
+	if a.longRunningRE == nil {
+		return false
+	}
+	return a.longRunningRE.MatchString(r.URL.Path)
+}
+
+// Middleware enforces the max-in-flight semaphore, exempting long-running
+// requests. When the semaphore is full it responds 429 with a Retry-After
+// header instead of blocking.
+func (a *Admission) Middleware(next http.Handler) http.Handler {
+	if a.sem == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isLongRunning(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case a.sem <- struct{}{}:
+			atomic.AddInt64(&a.inFlight, 1)
+			defer func() {
+				atomic.AddInt64(&a.inFlight, -1)
+				<-a.sem
+			}()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many requests in flight", http.StatusTooManyRequests)
+		}
+	})
+}
+
+// TimeoutMiddleware applies http.TimeoutHandler to every request not
+// classified as long-running.
+func (a *Admission) TimeoutMiddleware(next http.Handler) http.Handler {
+	if a.timeout <= 0 {
+		return next
+	}
+
+	timeoutHandler := http.TimeoutHandler(next, a.timeout, "request timed out")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.isLongRunning(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeoutHandler.ServeHTTP(w, r)
+	})
+}
+
+// Purge is a no-op placeholder for symmetry with Server.Purge; admission
+// holds no resources that outlive the process.
+func (a *Admission) Purge() {}
+
+// InFlight returns the current number of in-flight requests counted
+// against the semaphore.
+func (a *Admission) InFlight() int64 {
+	return atomic.LoadInt64(&a.inFlight)
+}
+
+// MarshalJSON reports the admission configuration alongside a live snapshot
+// of in-flight requests, so the SIGUSR1 state dump reflects current
+// saturation rather than just static config.
+func (a *Admission) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		MaxInFlight          int    `json:"maxInFlight"`
+		InFlight             int64  `json:"inFlight"`
+		RequestTimeout       string `json:"requestTimeout"`
+		LongRunningRequestRE string `json:"longRunningRequestRegex,omitempty"`
+	}{
+		MaxInFlight:    a.maxInFlight,
+		InFlight:       a.InFlight(),
+		RequestTimeout: a.timeout.String(),
+		LongRunningRequestRE: func() string {
+			if a.longRunningRE == nil {
+				return ""
+			}
+			return a.longRunningRE.String()
+		}(),
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nildev/api-host/config"
+)
+
+func TestAdmissionIsLongRunning(t *testing.T) {
+	a, err := NewAdmission(config.Config{LongRunningRequestRE: "^/(watch|events)"})
+	if err != nil {
+		t.Fatalf("NewAdmission: %v", err)
+	}
+
+	cases := []struct {
+		path string
+		want bool
+	}{
+		{"/watch/foo", true},
+		{"/events", true},
+		{"/users/1", false},
+		{"/", false},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if got := a.isLongRunning(r); got != c.want {
+			t.Errorf("isLongRunning(%q) = %v, want %v", c.path, got, c.want)
+		}
+	}
+}
+
+func TestAdmissionIsLongRunningDisabled(t *testing.T) {
+	a, err := NewAdmission(config.Config{})
+	if err != nil {
+		t.Fatalf("NewAdmission: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/watch/foo", nil)
+	if a.isLongRunning(r) {
+		t.Error("isLongRunning should be false when LongRunningRequestRE is unset")
+	}
+}
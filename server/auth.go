@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nildev/api-host/config"
+)
+
+// Claims is the set of claims extracted from a verified token, made
+// available to downstream handlers via the request context.
+type Claims map[string]interface{}
+
+type claimsContextKeyType struct{}
+
+var claimsContextKey = claimsContextKeyType{}
+
+// ClaimsFromContext returns the claims attached by AuthMiddleware, if any.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(Claims)
+	return claims, ok
+}
+
+// ErrMissingCredentials is returned by an Authenticator when the request
+// carries no usable credentials at all.
+var ErrMissingCredentials = errors.New("server: missing credentials")
+
+// Authenticator verifies a request's credentials and returns the resulting
+// claims, or an error if the request is unauthenticated.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Claims, error)
+}
+
+// bearerToken extracts the token from a "Bearer <token>" Authorization
+// header, the form shared by both the static-key and OIDC authenticators.
+func bearerToken(r *http.Request) (string, error) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return "", ErrMissingCredentials
+	}
+
+	const prefix = "Bearer "
+	if !strings.HasPrefix(h, prefix) {
+		return "", ErrMissingCredentials
+	}
+
+	return strings.TrimPrefix(h, prefix), nil
+}
+
+// staticKeyAuthenticator verifies tokens against a single configured key,
+// accepting either an HMAC secret (HS256) or a PEM encoded RSA public key
+// (RS256).
+type staticKeyAuthenticator struct {
+	hmacSecret []byte
+	rsaKey     *rsa.PublicKey
+}
+
+func newStaticKeyAuthenticator(mode, secret string) (*staticKeyAuthenticator, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("server: jwt_sign_key is required for auth_mode %q; an empty HMAC key is a publicly known key and would accept forged tokens", mode)
+	}
+
+	a := &staticKeyAuthenticator{hmacSecret: []byte(secret)}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(secret)); err == nil {
+		a.rsaKey = key
+	}
+
+	return a, nil
+}
+
+func (a *staticKeyAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	raw, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA:
+			if a.rsaKey == nil {
+				return nil, fmt.Errorf("server: no RSA key configured for RS256 token")
+			}
+			return a.rsaKey, nil
+		case *jwt.SigningMethodHMAC:
+			if a.rsaKey != nil {
+				return nil, fmt.Errorf("server: HMAC token rejected, jwt_sign_key is an RSA public key")
+			}
+			return a.hmacSecret, nil
+		default:
+			return nil, fmt.Errorf("server: unsupported signing method %v", t.Header["alg"])
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("server: invalid token")
+	}
+
+	return Claims(claims), nil
+}
+
+// chainAuthenticator tries each Authenticator in order, returning the first
+// successful result. It is used for AuthMode "chain" to prefer OIDC while
+// falling back to the static key.
+type chainAuthenticator struct {
+	authenticators []Authenticator
+}
+
+func (c *chainAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	var lastErr error
+	for _, a := range c.authenticators {
+		claims, err := a.Authenticate(r)
+		if err == nil {
+			return claims, nil
+		}
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = ErrMissingCredentials
+	}
+	return nil, lastErr
+}
+
+// buildAuthenticator constructs the Authenticator configured by cfg.AuthMode.
+// An empty AuthMode with no jwt_sign_key set disables authentication
+// entirely, preserving the prior behavior of the server.
+func buildAuthenticator(cfg config.Config) (Authenticator, error) {
+	mode := cfg.AuthMode
+	if mode == "" {
+		if cfg.Secret == "" {
+			return nil, nil
+		}
+		mode = "jwt"
+	}
+
+	switch mode {
+	case "jwt":
+		return newStaticKeyAuthenticator(mode, cfg.Secret)
+	case "oidc":
+		return newOIDCAuthenticator(cfg)
+	case "chain":
+		oidcAuth, err := newOIDCAuthenticator(cfg)
+		if err != nil {
+			return nil, err
+		}
+		jwtAuth, err := newStaticKeyAuthenticator(mode, cfg.Secret)
+		if err != nil {
+			return nil, err
+		}
+		return &chainAuthenticator{authenticators: []Authenticator{oidcAuth, jwtAuth}}, nil
+	default:
+		return nil, fmt.Errorf("server: unknown auth_mode %q", mode)
+	}
+}
+
+// AuthMiddleware verifies the request using auth and injects the resulting
+// claims into the request context. A nil auth disables authentication.
+func AuthMiddleware(auth Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if auth == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := auth.Authenticate(r)
+			if err != nil {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nildev/api-host/config"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker trips open once a route accumulates enough requests at a
+// failure ratio at or above the configured threshold, shedding load for
+// openDuration before allowing a single half-open trial request through.
+type circuitBreaker struct {
+	failureRatio float64
+	minRequests  int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	openedAt time.Time
+	requests int
+	failures int
+}
+
+func newCircuitBreaker(cfg config.Config) *circuitBreaker {
+	return &circuitBreaker{
+		failureRatio: cfg.CBFailureRatio,
+		minRequests:  cfg.CBMinRequests,
+		openDuration: cfg.CBOpenDuration,
+	}
+}
+
+// Allow reports whether a request may proceed through the breaker.
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.openDuration {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Only the request that flipped us into half-open is allowed
+		// through; later callers are rejected until it reports its result.
+		return false
+	default:
+		return true
+	}
+}
+
+// RecordResult updates the breaker's rolling counts and trips it open if
+// the failure ratio threshold is crossed.
+func (b *circuitBreaker) RecordResult(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		if success {
+			b.state = breakerClosed
+			b.requests, b.failures = 0, 0
+		} else {
+			b.trip()
+		}
+		return
+	}
+
+	b.requests++
+	if !success {
+		b.failures++
+	}
+
+	if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.failureRatio {
+		b.trip()
+	}
+}
+
+// trip must be called with b.mu held.
+func (b *circuitBreaker) trip() {
+	b.state = breakerOpen
+	b.openedAt = time.Now()
+	b.requests, b.failures = 0, 0
+}
+
+// breakerRegistry lazily creates one circuitBreaker per route. Routes are
+// keyed by the registered mux pattern (see routeLabel), not the literal
+// request path, so the map stays bounded to the fixed, small set of routes
+// the generated API handlers register, unlike the rate limiter's per-client
+// key space.
+type breakerRegistry struct {
+	cfg config.Config
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newBreakerRegistry(cfg config.Config) *breakerRegistry {
+	return &breakerRegistry{
+		cfg:      cfg,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+func (r *breakerRegistry) forRoute(route string) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[route]
+	if !ok {
+		b = newCircuitBreaker(r.cfg)
+		r.breakers[route] = b
+	}
+	return b
+}
+
+// breakerError is the structured JSON body returned while a route's breaker
+// is open.
+type breakerError struct {
+	Error string `json:"error"`
+	Route string `json:"route"`
+}
+
+// Middleware rejects requests for a route whose breaker is open with a 503
+// structured JSON error, and records the downstream handler's outcome
+// (a 5xx response counts as a failure) against that route's breaker. A nil
+// registry (circuit breaking disabled) is a pass-through.
+func (r *breakerRegistry) Middleware(next http.Handler) http.Handler {
+	if r == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		route := routeLabel(req)
+		b := r.forRoute(route)
+
+		if !b.Allow() {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(breakerError{
+				Error: "circuit breaker open",
+				Route: route,
+			})
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		b.RecordResult(rec.status < http.StatusInternalServerError)
+	})
+}
+
+// statusRecorder captures the status code written by a downstream handler
+// so middleware can observe it after ServeHTTP returns.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
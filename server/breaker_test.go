@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nildev/api-host/config"
+)
+
+func newTestBreaker(openDuration time.Duration) *circuitBreaker {
+	return newCircuitBreaker(config.Config{
+		CBFailureRatio: 0.5,
+		CBMinRequests:  2,
+		CBOpenDuration: openDuration,
+	})
+}
+
+func TestCircuitBreakerTripsOnFailureRatio(t *testing.T) {
+	b := newTestBreaker(time.Minute)
+
+	b.RecordResult(false)
+	if !b.Allow() {
+		t.Fatal("Allow() = false before minRequests is reached, want true")
+	}
+
+	b.RecordResult(false)
+	if b.Allow() {
+		t.Fatal("Allow() = true after the failure ratio threshold was crossed, want false (open)")
+	}
+}
+
+func TestCircuitBreakerHalfOpenAfterOpenDuration(t *testing.T) {
+	b := newTestBreaker(10 * time.Millisecond)
+
+	b.RecordResult(false)
+	b.RecordResult(false)
+	if b.Allow() {
+		t.Fatal("breaker should be open immediately after tripping")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatal("Allow() = false once openDuration elapsed, want true (half-open trial)")
+	}
+	if b.Allow() {
+		t.Fatal("a second concurrent caller during half-open should be rejected")
+	}
+}
+
+func TestCircuitBreakerHalfOpenSuccessCloses(t *testing.T) {
+	b := newTestBreaker(10 * time.Millisecond)
+
+	b.RecordResult(false)
+	b.RecordResult(false)
+	time.Sleep(20 * time.Millisecond)
+	b.Allow() // flips to half-open
+
+	b.RecordResult(true)
+
+	if b.state != breakerClosed {
+		t.Fatalf("state = %v after a successful half-open trial, want breakerClosed", b.state)
+	}
+	if !b.Allow() {
+		t.Fatal("Allow() = false after closing, want true")
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	b := newTestBreaker(10 * time.Millisecond)
+
+	b.RecordResult(false)
+	b.RecordResult(false)
+	time.Sleep(20 * time.Millisecond)
+	b.Allow() // flips to half-open
+
+	b.RecordResult(false)
+
+	if b.state != breakerOpen {
+		t.Fatalf("state = %v after a failed half-open trial, want breakerOpen", b.state)
+	}
+}
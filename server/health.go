@@ -0,0 +1,39 @@
+package server
+
+import "net/http"
+
+// healthPath is probed by operators and, once systemd watchdog
+// notification is configured, by apihostd itself before each WATCHDOG=1
+// ping.
+const healthPath = "/healthz"
+
+// healthHandler reports 200 as long as the process is able to serve this
+// handler at all; it intentionally does not depend on downstream state.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// withHealthCheck serves healthPath directly, ahead of admission control,
+// rate limiting and authentication, so an operator or the systemd watchdog
+// probe can always reach it.
+func withHealthCheck(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == healthPath {
+			healthHandler(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newHealthAdminServer builds a plaintext http.Server serving only
+// healthPath, for mounting on the separate listener configured by
+// cfg.HealthListen. Unlike the main listener, it never applies TLS or a
+// client certificate requirement, so a watchdog probe that cannot present
+// one can still reach it under tls_client_auth=require/require-and-verify.
+func newHealthAdminServer(listen string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, healthHandler)
+	return &http.Server{Addr: listen, Handler: mux}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nildev/api-host/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Metrics holds the Prometheus collectors for the server's request path,
+// registered against a private registry so apihostd's metrics endpoint
+// never picks up collectors registered by imported libraries.
+type Metrics struct {
+	path   string
+	listen string
+
+	registry *prometheus.Registry
+
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+	inFlight        *prometheus.GaugeVec
+
+	admin *http.Server
+
+	// cumulativeRequests and currentInFlight back Snapshot, so the SIGUSR1
+	// dump can report totals without reading back through the Prometheus
+	// registry.
+	cumulativeRequests int64
+	currentInFlight    int64
+}
+
+// NewMetrics builds the metrics collectors and, if cfg.MetricsListen is
+// set, an admin http.Server to serve them separately from the main mux.
+func NewMetrics(cfg config.Config) *Metrics {
+	registry := prometheus.NewRegistry()
+
+	m := &Metrics{
+		path:     cfg.MetricsPath,
+		listen:   cfg.MetricsListen,
+		registry: registry,
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "apihostd_requests_total",
+			Help: "Total number of HTTP requests handled, labeled by route, method and status class.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "apihostd_request_duration_seconds",
+			Help:    "HTTP request latency in seconds, labeled by route and method.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "apihostd_response_size_bytes",
+			Help:    "HTTP response size in bytes, labeled by route and method.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "apihostd_requests_in_flight",
+			Help: "Number of requests currently being served, labeled by route.",
+		}, []string{"route"}),
+	}
+
+	registry.MustRegister(m.requestsTotal, m.requestDuration, m.responseSize, m.inFlight)
+
+	if m.path == "" {
+		m.path = "/metrics"
+	}
+
+	if m.listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle(m.path, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+		m.admin = &http.Server{Addr: m.listen, Handler: mux}
+	}
+
+	return m
+}
+
+// Handler serves the metrics in Prometheus text format, for mounting on
+// the main mux when no separate admin listener is configured.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Run starts the admin listener, if configured. It is a no-op otherwise.
+func (m *Metrics) Run() error {
+	if m.admin == nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", m.admin.Addr)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := m.admin.Serve(ln); err != nil && err != http.ErrServerClosed {
+			log.Errorf("apihostd: metrics listener error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop closes the admin listener, if any.
+func (m *Metrics) Stop() {
+	if m.admin != nil {
+		m.admin.Close()
+	}
+}
+
+// statusClass reduces an HTTP status code to its class, e.g. 404 -> "4xx".
+func statusClass(status int) string {
+	return strconv.Itoa(status/100) + "xx"
+}
+
+// sizeRecorder wraps a ResponseWriter to track the number of bytes written,
+// for the response size histogram.
+type sizeRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *sizeRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *sizeRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+// Middleware records request counts, latency, response size and in-flight
+// gauge for every request, labeled by route (the registered mux pattern, not
+// the literal request path -- see routeLabel) and method.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(r)
+
+		atomic.AddInt64(&m.currentInFlight, 1)
+		m.inFlight.WithLabelValues(route).Inc()
+		defer func() {
+			atomic.AddInt64(&m.currentInFlight, -1)
+			m.inFlight.WithLabelValues(route).Dec()
+		}()
+
+		start := time.Now()
+		rec := &sizeRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		atomic.AddInt64(&m.cumulativeRequests, 1)
+		m.requestsTotal.WithLabelValues(route, r.Method, statusClass(rec.status)).Inc()
+		m.requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		m.responseSize.WithLabelValues(route, r.Method).Observe(float64(rec.size))
+	})
+}
+
+// metricsSnapshot is what the SIGUSR1 state dump reports for observability:
+// cumulative counters an operator can read without scraping the metrics
+// endpoint.
+type metricsSnapshot struct {
+	RequestsTotal int64 `json:"requestsTotal"`
+	InFlightTotal int64 `json:"inFlightTotal"`
+}
+
+// Snapshot reports cumulative request count and current in-flight count.
+func (m *Metrics) Snapshot() metricsSnapshot {
+	return metricsSnapshot{
+		RequestsTotal: atomic.LoadInt64(&m.cumulativeRequests),
+		InFlightTotal: atomic.LoadInt64(&m.currentInFlight),
+	}
+}
+
+// MarshalJSON reports the cumulative snapshot, so the SIGUSR1 state dump
+// includes observability counters alongside the config and admission state.
+func (m *Metrics) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Snapshot())
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nildev/api-host/config"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const defaultJWKSRefreshInterval = 5 * time.Minute
+
+// oidcDiscoveryDoc is the subset of the OIDC discovery document
+// (".well-known/openid-configuration") that the authenticator needs.
+type oidcDiscoveryDoc struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// jwksKey is a single entry of a JSON Web Key Set, restricted to the RSA
+// fields apihostd's issuers are expected to publish.
+type jwksKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDoc struct {
+	Keys []jwksKey `json:"keys"`
+}
+
+// oidcAuthenticator verifies tokens against keys published by an OIDC
+// issuer's JWKS endpoint, refreshing the key set periodically in the
+// background.
+type oidcAuthenticator struct {
+	issuer         string
+	audience       string
+	clientID       string
+	requiredClaims map[string]string
+	jwksURL        string
+
+	mu   sync.RWMutex
+	keys map[string]*rsa.PublicKey
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+func newOIDCAuthenticator(cfg config.Config) (*oidcAuthenticator, error) {
+	if cfg.OIDCIssuerURL == "" {
+		return nil, fmt.Errorf("server: oidc_issuer_url is required for auth_mode=oidc")
+	}
+
+	doc, err := discoverOIDCIssuer(cfg.OIDCIssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("server: oidc discovery failed: %v", err)
+	}
+
+	audience := cfg.OIDCAudience
+	if audience == "" {
+		audience = cfg.OIDCClientID
+	}
+
+	a := &oidcAuthenticator{
+		issuer:         doc.Issuer,
+		audience:       audience,
+		clientID:       cfg.OIDCClientID,
+		requiredClaims: cfg.OIDCRequiredClaims,
+		jwksURL:        doc.JWKSURI,
+		keys:           make(map[string]*rsa.PublicKey),
+		stopCh:         make(chan struct{}),
+	}
+
+	if err := a.refreshKeys(); err != nil {
+		return nil, fmt.Errorf("server: initial jwks fetch failed: %v", err)
+	}
+
+	interval := cfg.OIDCJWKSRefreshInterval
+	if interval <= 0 {
+		interval = defaultJWKSRefreshInterval
+	}
+	go a.refreshLoop(interval)
+
+	return a, nil
+}
+
+func discoverOIDCIssuer(issuerURL string) (*oidcDiscoveryDoc, error) {
+	resp, err := http.Get(strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from discovery endpoint", resp.StatusCode)
+	}
+
+	var doc oidcDiscoveryDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return &doc, nil
+}
+
+func (a *oidcAuthenticator) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.refreshKeys(); err != nil {
+				log.Warnf("apihostd: oidc jwks refresh failed: %v", err)
+			}
+		case <-a.stopCh:
+			return
+		}
+	}
+}
+
+func (a *oidcAuthenticator) refreshKeys() error {
+	resp, err := http.Get(a.jwksURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from jwks endpoint", resp.StatusCode)
+	}
+
+	var doc jwksDoc
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		key, err := jwkToRSAPublicKey(k)
+		if err != nil {
+			log.Warnf("apihostd: skipping jwks key %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+
+	return nil
+}
+
+func jwkToRSAPublicKey(k jwksKey) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %v", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %v", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+func (a *oidcAuthenticator) keyForKid(kid string) (*rsa.PublicKey, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+func (a *oidcAuthenticator) Authenticate(r *http.Request) (Claims, error) {
+	raw, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := a.keyForKid(kid)
+		if !ok {
+			return nil, fmt.Errorf("server: no matching jwks key for kid %q", kid)
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("server: invalid token")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != a.issuer {
+		return nil, fmt.Errorf("server: unexpected issuer %q", iss)
+	}
+
+	if a.audience != "" && !claims.VerifyAudience(a.audience, true) {
+		return nil, fmt.Errorf("server: token does not satisfy required audience")
+	}
+
+	for k, v := range a.requiredClaims {
+		if fmt.Sprintf("%v", claims[k]) != v {
+			return nil, fmt.Errorf("server: required claim %q not satisfied", k)
+		}
+	}
+
+	return Claims(claims), nil
+}
+
+// Stop ends the background JWKS refresh loop.
+func (a *oidcAuthenticator) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stopCh)
+	})
+}
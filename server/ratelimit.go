@@ -0,0 +1,304 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nildev/api-host/config"
+)
+
+const rateLimitShardCount = 32
+
+// defaultBucketIdleTTL is how long an unused token bucket is kept before the
+// sweeper reclaims it.
+const defaultBucketIdleTTL = 10 * time.Minute
+
+// compiledRateLimitRule is a config.RateLimitRule with its route pattern
+// precompiled as a regex, allowing both globs (translated to regex) and
+// regexes to be configured interchangeably.
+type compiledRateLimitRule struct {
+	route *regexp.Regexp
+	rps   float64
+	burst int
+	key   string
+}
+
+// tokenBucket is a classic token-bucket limiter: tokens refill continuously
+// at rps and are capped at burst.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	rps      float64
+	burst    int
+	lastSeen time.Time
+}
+
+func newTokenBucket(rps float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		tokens:   float64(burst),
+		rps:      rps,
+		burst:    burst,
+		lastSeen: time.Now(),
+	}
+}
+
+// Allow reports whether a request may proceed, consuming one token if so.
+func (b *tokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(b.lastSeen).Seconds()
+	b.lastSeen = now
+
+	b.tokens += elapsed * b.rps
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) idleSince(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.lastSeen)
+}
+
+// rateLimitShard holds a fraction of the overall key space so that
+// concurrent requests for different keys don't contend on a single lock.
+type rateLimitShard struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// RateLimiter enforces per-route, per-key request rates using sharded
+// token buckets, with unused buckets reclaimed by a background sweeper.
+type RateLimiter struct {
+	enabled        bool
+	defaultRPS     float64
+	defaultBurst   int
+	rules          []compiledRateLimitRule
+	trustedProxies []*net.IPNet
+	shards         [rateLimitShardCount]*rateLimitShard
+	stopOnce       sync.Once
+	stopCh         chan struct{}
+}
+
+// NewRateLimiter builds a RateLimiter from cfg. A disabled limiter's
+// Middleware is a pass-through.
+func NewRateLimiter(cfg config.Config) (*RateLimiter, error) {
+	rl := &RateLimiter{
+		enabled:      cfg.RateLimitEnabled,
+		defaultRPS:   cfg.RateLimitDefaultRPS,
+		defaultBurst: cfg.RateLimitBurst,
+		stopCh:       make(chan struct{}),
+	}
+
+	for i := range rl.shards {
+		rl.shards[i] = &rateLimitShard{buckets: make(map[string]*tokenBucket)}
+	}
+
+	for _, r := range cfg.RateLimitRules {
+		re, err := compileRoutePattern(r.Route)
+		if err != nil {
+			return nil, err
+		}
+		rl.rules = append(rl.rules, compiledRateLimitRule{
+			route: re,
+			rps:   r.RPS,
+			burst: r.Burst,
+			key:   r.Key,
+		})
+	}
+
+	for _, cidr := range cfg.RateLimitTrustedProxies {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			if ip := net.ParseIP(cidr); ip != nil {
+				bits := 32
+				if ip.To4() == nil {
+					bits = 128
+				}
+				_, n, _ = net.ParseCIDR(fmt.Sprintf("%s/%d", ip.String(), bits))
+			}
+		}
+		if n != nil {
+			rl.trustedProxies = append(rl.trustedProxies, n)
+		}
+	}
+
+	if rl.enabled {
+		go rl.sweepLoop()
+	}
+
+	return rl, nil
+}
+
+// compileRoutePattern allows a rule's Route to be either a regex or a
+// simple "*" glob, translating the glob form to an equivalent regex.
+func compileRoutePattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.Contains(pattern, "*") {
+		return regexp.Compile(pattern)
+	}
+
+	escaped := regexp.QuoteMeta(pattern)
+	escaped = strings.Replace(escaped, `\*`, ".*", -1)
+	return regexp.Compile("^" + escaped + "$")
+}
+
+// ruleFor returns the first rule whose route matches r's path, or the
+// default rps/burst/IP-keyed rule if none match.
+func (rl *RateLimiter) ruleFor(r *http.Request) (rps float64, burst int, key string) {
+	for _, rule := range rl.rules {
+		if rule.route.MatchString(r.URL.Path) {
+			return rule.rps, rule.burst, rule.key
+		}
+	}
+	return rl.defaultRPS, rl.defaultBurst, "ip"
+}
+
+// bucketKeyFor derives the identity used for bucketing: the client IP
+// (honoring X-Forwarded-For only from trusted proxies), the JWT subject
+// claim attached by AuthMiddleware, or an arbitrary request header.
+func (rl *RateLimiter) bucketKeyFor(r *http.Request, key string) string {
+	switch {
+	case key == "jwt_subject":
+		if claims, ok := ClaimsFromContext(r.Context()); ok {
+			if sub, ok := claims["sub"].(string); ok {
+				return sub
+			}
+		}
+		return rl.clientIP(r)
+	case strings.HasPrefix(key, "header:"):
+		name := strings.TrimPrefix(key, "header:")
+		if v := r.Header.Get(name); v != "" {
+			return v
+		}
+		return rl.clientIP(r)
+	default:
+		return rl.clientIP(r)
+	}
+}
+
+// clientIP returns the request's remote IP, trusting X-Forwarded-For only
+// when RemoteAddr falls within a configured trusted proxy CIDR.
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	remote := net.ParseIP(host)
+	if remote == nil || !rl.isTrustedProxy(remote) {
+		return host
+	}
+
+	xff := r.Header.Get("X-Forwarded-For")
+	if xff == "" {
+		return host
+	}
+
+	parts := strings.Split(xff, ",")
+	return strings.TrimSpace(parts[0])
+}
+
+func (rl *RateLimiter) isTrustedProxy(ip net.IP) bool {
+	for _, n := range rl.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// shardFor deterministically maps a bucket key to one of the limiter's
+// shards.
+func (rl *RateLimiter) shardFor(bucketKey string) *rateLimitShard {
+	h := fnv.New32a()
+	h.Write([]byte(bucketKey))
+	return rl.shards[h.Sum32()%rateLimitShardCount]
+}
+
+func (rl *RateLimiter) allow(route, key string, rps float64, burst int) bool {
+	bucketKey := route + "|" + key
+	shard := rl.shardFor(bucketKey)
+
+	shard.mu.Lock()
+	b, ok := shard.buckets[bucketKey]
+	if !ok {
+		b = newTokenBucket(rps, burst)
+		shard.buckets[bucketKey] = b
+	}
+	shard.mu.Unlock()
+
+	return b.Allow()
+}
+
+// sweepLoop periodically reclaims buckets that have gone unused, so the
+// limiter's memory tracks active keys rather than all keys ever seen.
+func (rl *RateLimiter) sweepLoop() {
+	ticker := time.NewTicker(defaultBucketIdleTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rl.sweep()
+		case <-rl.stopCh:
+			return
+		}
+	}
+}
+
+func (rl *RateLimiter) sweep() {
+	now := time.Now()
+	for _, shard := range rl.shards {
+		shard.mu.Lock()
+		for k, b := range shard.buckets {
+			if b.idleSince(now) > defaultBucketIdleTTL {
+				delete(shard.buckets, k)
+			}
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// Stop ends the background sweeper.
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() {
+		close(rl.stopCh)
+	})
+}
+
+// Middleware rejects requests exceeding the configured rate with a 429, and
+// is a pass-through when rate limiting is disabled.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	if !rl.enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rps, burst, key := rl.ruleFor(r)
+		bucketKey := rl.bucketKeyFor(r, key)
+
+		if !rl.allow(routeLabel(r), bucketKey, rps, burst) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
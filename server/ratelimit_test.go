@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowBurst(t *testing.T) {
+	b := newTokenBucket(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Fatalf("Allow() #%d = false, want true within burst", i)
+		}
+	}
+	if b.Allow() {
+		t.Fatal("Allow() = true once burst is exhausted, want false")
+	}
+}
+
+func TestTokenBucketAllowRefill(t *testing.T) {
+	b := newTokenBucket(1, 1)
+
+	if !b.Allow() {
+		t.Fatal("Allow() = false on first call, want true")
+	}
+	if b.Allow() {
+		t.Fatal("Allow() = true immediately after exhausting burst, want false")
+	}
+
+	b.lastSeen = b.lastSeen.Add(-2 * time.Second)
+	if !b.Allow() {
+		t.Fatal("Allow() = false after refill interval elapsed, want true")
+	}
+}
+
+func TestTokenBucketAllowCapsAtBurst(t *testing.T) {
+	b := newTokenBucket(100, 2)
+
+	b.lastSeen = b.lastSeen.Add(-time.Hour)
+	b.Allow()
+	b.Allow()
+	if b.Allow() {
+		t.Fatal("Allow() = true beyond the burst cap even after a long idle period, want false")
+	}
+}
+
+func TestCompileRoutePatternGlob(t *testing.T) {
+	re, err := compileRoutePattern("/users/*")
+	if err != nil {
+		t.Fatalf("compileRoutePattern: %v", err)
+	}
+
+	if !re.MatchString("/users/123") {
+		t.Error("expected /users/* to match /users/123")
+	}
+	if re.MatchString("/accounts/123") {
+		t.Error("expected /users/* not to match /accounts/123")
+	}
+}
+
+func TestCompileRoutePatternRegex(t *testing.T) {
+	re, err := compileRoutePattern("^/search")
+	if err != nil {
+		t.Fatalf("compileRoutePattern: %v", err)
+	}
+
+	if !re.MatchString("/search?q=x") {
+		t.Error("expected ^/search to match /search?q=x")
+	}
+	if re.MatchString("/other") {
+		t.Error("expected ^/search not to match /other")
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import "net/http"
+
+// unmatchedRoute labels requests that don't match any pattern registered on
+// the mux (404s), so an attacker scanning arbitrary paths can't use those
+// requests to grow a route-keyed map or Prometheus label set without bound.
+const unmatchedRoute = "unmatched"
+
+// routeLabel returns the registered mux pattern that matches r, rather than
+// the literal request path, so callers that key maps or Prometheus labels by
+// route stay bounded to the fixed, small set of routes the generated API
+// handlers register -- not the unbounded set of concrete paths a REST API
+// with ID segments (e.g. /users/123) can otherwise produce.
+func routeLabel(r *http.Request) string {
+	if _, pattern := http.DefaultServeMux.Handler(r); pattern != "" {
+		return pattern
+	}
+	return unmatchedRoute
+}
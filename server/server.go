@@ -0,0 +1,258 @@
+// Package server wires together the HTTP runtime for apihostd: routing,
+// CORS, authentication and the various production hardening middlewares
+// (admission control, rate limiting, observability, TLS) layered on top.
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/nildev/api-host/config"
+	"github.com/rs/cors"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Server owns the listener and HTTP server for one generation of
+// configuration. A new Server is created on every SIGHUP reconfigure;
+// the previous one is stopped before the new one is built and bound, so
+// connections arriving in between are refused, while requests already in
+// flight on the old Server's connections are not cut off.
+type Server struct {
+	Config config.Config `json:"config"`
+
+	Admission *Admission `json:"admission"`
+	Metrics   *Metrics   `json:"metrics"`
+
+	auth        Authenticator
+	rateLimiter *RateLimiter
+	breakers    *breakerRegistry
+	tracing     *Tracing
+	tlsManager  *TLSManager
+
+	httpServer *http.Server
+	listener   net.Listener
+
+	healthAdmin    *http.Server
+	healthListener net.Listener
+}
+
+// New builds a Server from cfg but does not start the main listener; call
+// Run to bind it and begin serving. With ACME enabled, New already binds
+// and serves the :http challenge listener (see NewTLSManager).
+func New(cfg config.Config) (*Server, error) {
+	admission, err := NewAdmission(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := buildAuthenticator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimiter, err := NewRateLimiter(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var breakers *breakerRegistry
+	if cfg.CBMinRequests > 0 {
+		breakers = newBreakerRegistry(cfg)
+	}
+
+	tracing, err := NewTracing(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsManager, err := NewTLSManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		Config:      cfg,
+		Admission:   admission,
+		Metrics:     NewMetrics(cfg),
+		auth:        auth,
+		rateLimiter: rateLimiter,
+		breakers:    breakers,
+		tracing:     tracing,
+		tlsManager:  tlsManager,
+	}
+
+	handler := srv.buildHandler()
+
+	srv.httpServer = &http.Server{
+		Addr:    net.JoinHostPort(cfg.IP, cfg.Port),
+		Handler: handler,
+	}
+
+	srv.httpServer.TLSConfig, err = tlsManager.TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.HealthListen != "" {
+		srv.healthAdmin = newHealthAdminServer(cfg.HealthListen)
+	}
+
+	return srv, nil
+}
+
+// buildHandler assembles the middleware chain around the routes registered
+// by the generated API handlers (mounted on http.DefaultServeMux by the
+// codegen package imported for side effects in main).
+func (s *Server) buildHandler() http.Handler {
+	var handler http.Handler = http.DefaultServeMux
+	handler = withClientCert(s.Config)(handler)
+
+	handler = s.breakers.Middleware(handler)
+	handler = s.rateLimiter.Middleware(handler)
+	handler = AuthMiddleware(s.auth)(handler)
+	handler = s.Admission.TimeoutMiddleware(handler)
+	handler = s.Admission.Middleware(handler)
+	handler = s.Metrics.Middleware(handler)
+	handler = s.tracing.Middleware(handler)
+
+	c := cors.New(cors.Options{
+		AllowedOrigins:     s.Config.CORSAllowedOrigins,
+		AllowedMethods:     s.Config.CORSAllowedMethods,
+		AllowedHeaders:     s.Config.CORSAllowedHeaders,
+		ExposedHeaders:     s.Config.CORSExposedHeaders,
+		AllowCredentials:   s.Config.CORSAllowCredentials,
+		MaxAge:             s.Config.CORSMaxAge,
+		OptionsPassthrough: s.Config.CORSOptionsPassThrough,
+		Debug:              s.Config.CORSDebug,
+	})
+
+	handler = c.Handler(handler)
+
+	if s.Metrics.admin == nil {
+		mux := http.NewServeMux()
+		mux.Handle(s.Metrics.path, s.Metrics.Handler())
+		mux.Handle("/", handler)
+		handler = mux
+	}
+
+	// withHealthCheck must be the outermost wrapper: it is the only thing
+	// guaranteed to short-circuit ahead of every other middleware, so
+	// healthPath stays reachable even while auth is failing closed or
+	// admission control is shedding load under saturation — exactly when
+	// the systemd watchdog probe (see startWatchdog in api-host.go) and
+	// operators need it most.
+	handler = withHealthCheck(handler)
+
+	return handler
+}
+
+// Run starts listening and serves in the background. Errors encountered
+// while serving after a successful bind are logged but do not propagate,
+// matching the fire-and-forget contract callers already rely on.
+func (s *Server) Run() error {
+	ln, err := net.Listen("tcp", s.httpServer.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = ln
+
+	go func() {
+		var err error
+		if s.httpServer.TLSConfig != nil {
+			err = s.httpServer.ServeTLS(ln, "", "")
+		} else {
+			err = s.httpServer.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("apihostd: server error: %v", err)
+		}
+	}()
+
+	if s.healthAdmin != nil {
+		hln, err := net.Listen("tcp", s.healthAdmin.Addr)
+		if err != nil {
+			return err
+		}
+		s.healthListener = hln
+
+		go func() {
+			if err := s.healthAdmin.Serve(hln); err != nil && err != http.ErrServerClosed {
+				log.Errorf("apihostd: health listener error: %v", err)
+			}
+		}()
+	}
+
+	return s.Metrics.Run()
+}
+
+// stoppableAuthenticator is implemented by authenticators that hold
+// background resources (e.g. the OIDC JWKS refresh loop) needing cleanup
+// when a server generation is retired.
+type stoppableAuthenticator interface {
+	Stop()
+}
+
+// Stop closes the listener immediately, ending Run's Serve loop without
+// waiting for in-flight requests. It is used for the SIGHUP restart path,
+// where the replacement Server is created and bound right after Stop
+// returns; use Shutdown for a graceful drain on process exit.
+func (s *Server) Stop() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.healthListener != nil {
+		s.healthListener.Close()
+	}
+
+	s.cleanup()
+}
+
+// Shutdown stops accepting new connections and waits for in-flight
+// requests to complete, up to ctx's deadline, before returning. Use this
+// for the final SIGTERM/SIGINT drain; use Stop for a SIGHUP restart.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+
+	if s.healthAdmin != nil {
+		if herr := s.healthAdmin.Shutdown(ctx); herr != nil && err == nil {
+			err = herr
+		}
+	}
+
+	s.cleanup()
+
+	return err
+}
+
+// cleanup tears down background resources common to both Stop and
+// Shutdown: the authenticator, rate limiter sweeper, metrics admin
+// listener and tracer provider.
+func (s *Server) cleanup() {
+	stopAuthenticator(s.auth)
+	s.rateLimiter.Stop()
+	s.Metrics.Stop()
+	s.tlsManager.Stop()
+
+	if err := s.tracing.Shutdown(context.Background()); err != nil {
+		log.Warnf("apihostd: error shutting down tracer provider: %v", err)
+	}
+}
+
+func stopAuthenticator(auth Authenticator) {
+	switch a := auth.(type) {
+	case stoppableAuthenticator:
+		a.Stop()
+	case *chainAuthenticator:
+		for _, inner := range a.authenticators {
+			stopAuthenticator(inner)
+		}
+	}
+}
+
+// Purge releases any remaining resources held by the server. It is called
+// once after Shutdown during final shutdown, as opposed to a SIGHUP restart.
+func (s *Server) Purge() {
+	s.Admission.Purge()
+}
@@ -0,0 +1,332 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nildev/api-host/config"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuthModes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify-if-given":    tls.VerifyClientCertIfGiven,
+	"require-and-verify": tls.RequireAndVerifyClientCert,
+}
+
+// TLSManager owns TLS termination for the server: either a certificate
+// loaded from disk and hot-reloaded on change, or certificates obtained
+// automatically via ACME.
+type TLSManager struct {
+	enabled bool
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	watcher  *fsnotify.Watcher
+	stopOnce sync.Once
+	stopCh   chan struct{}
+
+	acmeManager  *autocert.Manager
+	acmeListener net.Listener
+}
+
+// NewTLSManager builds a TLSManager from cfg. It returns a disabled
+// manager (TLSConfig returns nil) when neither static certs nor ACME are
+// configured.
+func NewTLSManager(cfg config.Config) (*TLSManager, error) {
+	if cfg.ACMEEnabled {
+		return newACMETLSManager(cfg)
+	}
+
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return &TLSManager{enabled: false}, nil
+	}
+
+	return newStaticTLSManager(cfg)
+}
+
+func newStaticTLSManager(cfg config.Config) (*TLSManager, error) {
+	m := &TLSManager{
+		enabled: true,
+		stopCh:  make(chan struct{}),
+	}
+
+	if err := m.loadCertificate(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("server: creating tls cert watcher: %v", err)
+	}
+	m.watcher = watcher
+
+	for _, dir := range uniqueDirs(cfg.TLSCertFile, cfg.TLSKeyFile) {
+		if err := watcher.Add(dir); err != nil {
+			return nil, fmt.Errorf("server: watching %s for tls cert reload: %v", dir, err)
+		}
+	}
+
+	go m.watchLoop(cfg.TLSCertFile, cfg.TLSKeyFile)
+
+	return m, nil
+}
+
+func uniqueDirs(paths ...string) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range paths {
+		dir := filepath.Dir(p)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (m *TLSManager) loadCertificate(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("server: loading tls certificate: %v", err)
+	}
+
+	m.mu.Lock()
+	m.cert = &cert
+	m.mu.Unlock()
+
+	return nil
+}
+
+func (m *TLSManager) watchLoop(certFile, keyFile string) {
+	for {
+		select {
+		case event, ok := <-m.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			if err := m.loadCertificate(certFile, keyFile); err != nil {
+				log.Warnf("apihostd: failed to reload tls certificate: %v", err)
+			} else {
+				log.Infof("apihostd: reloaded tls certificate from %s", certFile)
+			}
+		case err, ok := <-m.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("apihostd: tls cert watcher error: %v", err)
+		case <-m.stopCh:
+			return
+		}
+	}
+}
+
+func (m *TLSManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cert, nil
+}
+
+func newACMETLSManager(cfg config.Config) (*TLSManager, error) {
+	if len(cfg.ACMEHosts) == 0 {
+		return nil, fmt.Errorf("server: acme_hosts is required when acme_enabled is set")
+	}
+
+	mgr := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEHosts...),
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		Email:      cfg.ACMEEmail,
+	}
+
+	if cfg.ACMEDirectoryURL != "" {
+		mgr.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	}
+
+	m := &TLSManager{
+		enabled:     true,
+		stopCh:      make(chan struct{}),
+		acmeManager: mgr,
+	}
+
+	// The HTTP-01 challenge (and plain HTTP->HTTPS redirects) need a
+	// listener on :http; TLS-ALPN-01 is handled automatically by
+	// GetCertificate as part of the HTTPS listener's handshake.
+	ln, err := net.Listen("tcp", ":http")
+	if err != nil {
+		return nil, fmt.Errorf("server: binding acme http-01 challenge listener: %v", err)
+	}
+	m.acmeListener = ln
+
+	go func() {
+		if err := http.Serve(ln, mgr.HTTPHandler(nil)); err != nil && err != http.ErrServerClosed {
+			log.Warnf("apihostd: acme challenge listener error: %v", err)
+		}
+	}()
+
+	return m, nil
+}
+
+func (m *TLSManager) getCertificateFn() func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if m.acmeManager != nil {
+		return m.acmeManager.GetCertificate
+	}
+	return m.getCertificate
+}
+
+// TLSConfig builds the *tls.Config to use for the main listener, or nil if
+// TLS is not configured. cfg supplies the minimum version, cipher suite
+// and client auth settings layered on top of the certificate source.
+func (m *TLSManager) TLSConfig(cfg config.Config) (*tls.Config, error) {
+	if !m.enabled {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{
+		GetCertificate: m.getCertificateFn(),
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if m.acmeManager != nil {
+		// The TLS-ALPN-01 handshake only succeeds if acme.ALPNProto can be
+		// negotiated on the HTTPS listener.
+		tlsCfg.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+
+	if cfg.TLSMinVersion != "" {
+		v, ok := tlsVersions[cfg.TLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("server: unknown tls_min_version %q", cfg.TLSMinVersion)
+		}
+		tlsCfg.MinVersion = v
+	}
+
+	authMode, ok := tlsClientAuthModes[cfg.TLSClientAuth]
+	if !ok {
+		return nil, fmt.Errorf("server: unknown tls_client_auth %q", cfg.TLSClientAuth)
+	}
+	tlsCfg.ClientAuth = authMode
+
+	if cfg.TLSClientCA != "" {
+		pemBytes, err := ioutil.ReadFile(cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("server: reading tls_client_ca: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, fmt.Errorf("server: no certificates found in tls_client_ca")
+		}
+		tlsCfg.ClientCAs = pool
+	}
+
+	if len(cfg.TLSCiphers) > 0 {
+		suites, err := resolveCipherSuites(cfg.TLSCiphers)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.CipherSuites = suites
+	}
+
+	return tlsCfg, nil
+}
+
+func resolveCipherSuites(names []string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	suites := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("server: unknown tls cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+
+	return suites, nil
+}
+
+// Stop ends the certificate watcher or ACME challenge listener.
+func (m *TLSManager) Stop() {
+	if m == nil {
+		return
+	}
+
+	m.stopOnce.Do(func() {
+		if m.stopCh != nil {
+			close(m.stopCh)
+		}
+	})
+
+	if m.watcher != nil {
+		m.watcher.Close()
+	}
+	if m.acmeListener != nil {
+		m.acmeListener.Close()
+	}
+}
+
+type clientCertContextKeyType struct{}
+
+var clientCertContextKey = clientCertContextKeyType{}
+
+// ClientCertSubjectFromContext returns the verified client certificate's
+// subject, set by withClientCert when tls_client_auth=require-and-verify.
+func ClientCertSubjectFromContext(ctx context.Context) (string, bool) {
+	subject, ok := ctx.Value(clientCertContextKey).(string)
+	return subject, ok
+}
+
+// withClientCert injects the verified client certificate's subject into
+// the request context alongside any JWT claims AuthMiddleware adds,
+// letting downstream handlers use either identity. It only applies under
+// tls_client_auth=require-and-verify, and only trusts VerifiedChains: under
+// the weaker request/require modes, PeerCertificates is populated without
+// being checked against ClientCAs, so any client could otherwise present a
+// self-signed certificate with an arbitrary Subject and have it trusted.
+func withClientCert(cfg config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.TLSClientAuth != "require-and-verify" {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			subject := r.TLS.VerifiedChains[0][0].Subject.String()
+			ctx := context.WithValue(r.Context(), clientCertContextKey, subject)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
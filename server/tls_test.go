@@ -0,0 +1,29 @@
+package server
+
+import "testing"
+
+func TestResolveCipherSuites(t *testing.T) {
+	suites, err := resolveCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil {
+		t.Fatalf("resolveCipherSuites: %v", err)
+	}
+	if len(suites) != 1 {
+		t.Fatalf("resolveCipherSuites returned %d suites, want 1", len(suites))
+	}
+}
+
+func TestResolveCipherSuitesUnknown(t *testing.T) {
+	if _, err := resolveCipherSuites([]string{"NOT_A_REAL_CIPHER"}); err == nil {
+		t.Error("resolveCipherSuites should error on an unknown cipher suite name")
+	}
+}
+
+func TestResolveCipherSuitesEmpty(t *testing.T) {
+	suites, err := resolveCipherSuites(nil)
+	if err != nil {
+		t.Fatalf("resolveCipherSuites: %v", err)
+	}
+	if len(suites) != 0 {
+		t.Errorf("resolveCipherSuites(nil) = %v, want empty", suites)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/nildev/api-host/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Tracing holds the OpenTelemetry tracer provider for one server
+// generation, wired to an OTLP exporter when enabled.
+type Tracing struct {
+	enabled  bool
+	provider *sdktrace.TracerProvider
+}
+
+// NewTracing builds the OTLP exporter and tracer provider described by cfg.
+// When cfg.OTelEnabled is false it returns a disabled Tracing whose
+// Middleware is a pass-through.
+func NewTracing(cfg config.Config) (*Tracing, error) {
+	if !cfg.OTelEnabled {
+		return &Tracing{enabled: false}, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpoint(cfg.OTelEndpoint),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName(cfg.OTelServiceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.TraceIDRatioBased(cfg.OTelSampleRatio)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	log.AddHook(&traceHook{})
+
+	return &Tracing{enabled: true, provider: provider}, nil
+}
+
+// Middleware instruments the request with a span, propagating the W3C
+// traceparent header via otelhttp, and logs the completed request via a
+// context-carrying entry so traceHook can stamp it with the span's
+// trace_id/span_id. The logging handler must be the innermost handler
+// wrapped by otelhttp.NewHandler, since otelhttp attaches the span to the
+// request context before calling through. A disabled Tracing is a
+// pass-through.
+func (t *Tracing) Middleware(next http.Handler) http.Handler {
+	if !t.enabled {
+		return next
+	}
+
+	return otelhttp.NewHandler(t.loggingHandler(next), "apihostd")
+}
+
+// loggingHandler records one structured log entry per request, carrying
+// the request's context so traceHook can correlate it with the active
+// span.
+func (t *Tracing) loggingHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		next.ServeHTTP(rec, r)
+
+		log.WithContext(r.Context()).WithFields(log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   rec.status,
+			"duration": time.Since(start).String(),
+		}).Info("apihostd: request handled")
+	})
+}
+
+// Shutdown flushes and stops the tracer provider, if tracing is enabled.
+func (t *Tracing) Shutdown(ctx context.Context) error {
+	if !t.enabled || t.provider == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+// traceHook injects the active span's trace and span IDs into every
+// logrus entry carrying a context, so spans can be correlated with the
+// existing JSON log output.
+type traceHook struct{}
+
+func (h *traceHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h *traceHook) Fire(entry *log.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+
+	span := trace.SpanContextFromContext(entry.Context)
+	if !span.IsValid() {
+		return nil
+	}
+
+	entry.Data["trace_id"] = span.TraceID().String()
+	entry.Data["span_id"] = span.SpanID().String()
+
+	return nil
+}
@@ -0,0 +1,11 @@
+// Package version holds build-time metadata injected via -ldflags.
+package version
+
+var (
+	// Version is the semantic version of this build, set via -ldflags.
+	Version = ""
+	// GitHash is the git commit this binary was built from, set via -ldflags.
+	GitHash = ""
+	// BuiltTimestamp is the build time, set via -ldflags.
+	BuiltTimestamp = ""
+)